@@ -3,10 +3,10 @@ package main
 import (
 	"bufio"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"os"
-	"os/exec"
-	"strings"
+	"sync"
 	"time"
 )
 
@@ -14,38 +14,101 @@ type AgentTask struct {
 	ID      string   `json:"id"`
 	Command string   `json:"command"`
 	Args    []string `json:"args"`
+	// Parser names a lineParser (see parsers.go) run over each line of
+	// stdout/stderr to populate that event's Parsed field: "json" (one JSON
+	// value per line, not a multi-line document), "influx-line",
+	// "regex" (requires a "pattern" ParserArgs entry), or "test2json".
+	Parser     string            `json:"parser,omitempty"`
+	ParserArgs map[string]string `json:"parser_args,omitempty"`
+	Retry      *RetryPolicy      `json:"retry,omitempty"`
 }
 
-type AgentResult struct {
-	TaskID     string `json:"task_id"`
-	Success    bool   `json:"success"`
-	Output     string `json:"output"`
-	Error      string `json:"error,omitempty"`
-	ExecTimeMs int64  `json:"execution_time_ms"`
-}
+const defaultWallTime = 5 * time.Minute
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "sign" {
+		runSignCommand(os.Args[2:])
+		return
+	}
+
+	policyPath := flag.String("policy", os.Getenv("TERMUX_ULTRA_AGENT_POLICY"), "path to a policy file allow-listing permitted commands")
+	heartbeatSeconds := flag.Int("heartbeat-interval", int(defaultHeartbeatInterval/time.Second), "seconds between heartbeat events for idle tasks (0 disables)")
+	maxConcurrency := flag.Int("max-concurrency", 4, "maximum number of tasks to run at once")
+	listenAddr := flag.String("listen", "", "if set, run an HTTP server accepting task submissions at this address instead of reading stdin")
+	pubKeyPath := flag.String("pubkey", "", "PEM public key used to verify HTTP Signatures on -listen submissions (required when -listen is set)")
+	queueDir := flag.String("queue-dir", os.Getenv("TERMUX_ULTRA_AGENT_QUEUE_DIR"), "if set, persist task state here so tasks survive an agent restart")
+	flag.Parse()
+
+	var policy *Policy
+	if *policyPath != "" {
+		p, err := loadPolicy(*policyPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "termux-ultra-agent: %v\n", err)
+			os.Exit(1)
+		}
+		policy = p
+	}
+	heartbeat := time.Duration(*heartbeatSeconds) * time.Second
+	if *maxConcurrency < 1 {
+		*maxConcurrency = 1
+	}
+
+	var queue *durableQueue
+	if *queueDir != "" {
+		q, err := openQueue(*queueDir)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "termux-ultra-agent: %v\n", err)
+			os.Exit(1)
+		}
+		queue = q
+	}
+
+	reg := newRegistry()
+
+	if *listenAddr != "" {
+		if err := runListen(*listenAddr, policy, reg, heartbeat, *pubKeyPath, queue); err != nil {
+			fmt.Fprintf(os.Stderr, "termux-ultra-agent: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	sem := make(chan struct{}, *maxConcurrency)
+	var wg sync.WaitGroup
+
+	dispatch := func(task AgentTask, startAttempt int) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			if queue != nil {
+				queue.runTaskFrom(task, policy, heartbeat, reg, stdoutEvents.emit, startAttempt)
+			} else {
+				streamTask(task, policy, heartbeat, reg)
+			}
+		}()
+	}
+
+	if queue != nil {
+		if err := queue.recoverInterrupted(dispatch); err != nil {
+			fmt.Fprintf(os.Stderr, "termux-ultra-agent: recover interrupted tasks: %v\n", err)
+		}
+	}
+
 	scanner := bufio.NewScanner(os.Stdin)
 	for scanner.Scan() {
-		var task AgentTask
-		if err := json.Unmarshal(scanner.Bytes(), &task); err != nil {
-			fmt.Println(`{"success":false,"error":"Invalid JSON input"}`)
+		var msg inboundMessage
+		if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil {
+			fmt.Println(`{"event":"exit","success":false,"error":"Invalid JSON input"}`)
 			continue
 		}
-		start := time.Now()
-		cmd := exec.Command(task.Command, task.Args...)
-		output, err := cmd.CombinedOutput()
-		execTime := time.Since(start).Milliseconds()
-		result := AgentResult{
-			TaskID:     task.ID,
-			Success:    err == nil,
-			Output:     strings.TrimSpace(string(output)),
-			ExecTimeMs: execTime,
-		}
-		if err != nil {
-			result.Error = err.Error()
+		if msg.Control != "" {
+			handleControl(msg, reg, queue)
+			continue
 		}
-		resJSON, _ := json.Marshal(result)
-		fmt.Println(string(resJSON))
+
+		dispatch(msg.AgentTask, 1)
 	}
+	wg.Wait()
 }
@@ -0,0 +1,22 @@
+//go:build linux
+
+package main
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// applyChroot confines cmd's child to dir, per the policy's optional
+// "chroot" setting. It composes with setProcessGroup, which may have
+// already allocated cmd.SysProcAttr. Note cmd.Dir is interpreted relative
+// to dir once Chroot is set, matching os/exec's documented behavior.
+func applyChroot(cmd *exec.Cmd, dir string) {
+	if dir == "" {
+		return
+	}
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Chroot = dir
+}
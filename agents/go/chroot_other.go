@@ -0,0 +1,8 @@
+//go:build !linux
+
+package main
+
+import "os/exec"
+
+// applyChroot is a no-op on platforms without chroot(2) support.
+func applyChroot(cmd *exec.Cmd, dir string) {}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// runSignCommand implements `termux-ultra-agent sign`: it signs a JSON
+// payload with a PEM private key and prints the HTTP headers a caller
+// needs to attach to authenticate a POST to a -listen agent.
+func runSignCommand(args []string) {
+	fs := flag.NewFlagSet("sign", flag.ExitOnError)
+	keyPath := fs.String("key", "", "path to a PEM private key (RSA or Ed25519)")
+	keyID := fs.String("keyid", "agent", "keyId to embed in the Signature header")
+	method := fs.String("method", http.MethodPost, "HTTP method the signature targets")
+	path := fs.String("path", "/tasks", "request path the signature targets")
+	payloadPath := fs.String("payload", "", "file containing the JSON payload to sign (default: stdin)")
+	fs.Parse(args)
+
+	if *keyPath == "" {
+		fmt.Fprintln(os.Stderr, "termux-ultra-agent sign: -key is required")
+		os.Exit(1)
+	}
+
+	var payload []byte
+	var err error
+	if *payloadPath != "" {
+		payload, err = os.ReadFile(*payloadPath)
+	} else {
+		payload, err = io.ReadAll(os.Stdin)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "termux-ultra-agent sign: %v\n", err)
+		os.Exit(1)
+	}
+
+	signer, err := loadPrivateKey(*keyPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "termux-ultra-agent sign: %v\n", err)
+		os.Exit(1)
+	}
+
+	date := time.Now().UTC().Format(http.TimeFormat)
+	digest := computeDigest(payload)
+	signingStr := strings.Join([]string{
+		fmt.Sprintf("(request-target): %s %s", strings.ToLower(*method), *path),
+		"date: " + date,
+		"digest: " + digest,
+		"content-type: application/json",
+	}, "\n")
+
+	sig, algorithm, err := signBytes(signer, []byte(signingStr))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "termux-ultra-agent sign: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Date: %s\n", date)
+	fmt.Printf("Digest: %s\n", digest)
+	fmt.Printf("Content-Type: application/json\n")
+	fmt.Printf("Signature: keyId=%q,algorithm=%q,headers=%q,signature=%q\n",
+		*keyID, algorithm, defaultSignedHeaders, base64.StdEncoding.EncodeToString(sig))
+}
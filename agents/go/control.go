@@ -0,0 +1,59 @@
+package main
+
+// inboundMessage is a single line of agent stdin input: either a task to
+// run (Control empty) or a control message acting on a task already in
+// the registry or the durable queue.
+type inboundMessage struct {
+	AgentTask
+	Control string `json:"control,omitempty"`
+	Signal  string `json:"signal,omitempty"`
+	Since   string `json:"since,omitempty"`
+}
+
+// handleControl processes a single control message against the registry
+// and (when durable queuing is enabled) the on-disk queue, emitting
+// whatever reply event is appropriate. queue may be nil.
+func handleControl(msg inboundMessage, reg *registry, queue *durableQueue) {
+	switch msg.Control {
+	case "cancel":
+		h, ok := reg.get(msg.ID)
+		if !ok {
+			stdoutEvents.emit(StreamEvent{TaskID: msg.ID, Event: "exit", ExitResult: &ExitResult{}, ErrorCode: "unknown_task", Error: "no such running task"})
+			return
+		}
+		h.cancel()
+	case "signal":
+		h, ok := reg.get(msg.ID)
+		if !ok {
+			stdoutEvents.emit(StreamEvent{TaskID: msg.ID, Event: "exit", ExitResult: &ExitResult{}, ErrorCode: "unknown_task", Error: "no such running task"})
+			return
+		}
+		if err := killProcessGroup(h.cmd, msg.Signal); err != nil {
+			stdoutEvents.emit(StreamEvent{TaskID: msg.ID, Event: "exit", ExitResult: &ExitResult{}, ErrorCode: "signal_failed", Error: err.Error()})
+		}
+	case "list":
+		stdoutEvents.emit(StreamEvent{Event: "list", TaskIDs: reg.list()})
+	case "history":
+		if queue == nil {
+			stdoutEvents.emit(StreamEvent{Event: "history", ErrorCode: "queue_disabled", Error: "durable queue is not enabled"})
+			return
+		}
+		recs, err := queue.since(msg.Since)
+		if err != nil {
+			stdoutEvents.emit(StreamEvent{Event: "history", Error: err.Error()})
+			return
+		}
+		stdoutEvents.emit(StreamEvent{Event: "history", History: recs})
+	case "output":
+		if queue == nil {
+			stdoutEvents.emit(StreamEvent{TaskID: msg.ID, Event: "output", ErrorCode: "queue_disabled", Error: "durable queue is not enabled"})
+			return
+		}
+		out, err := queue.output(msg.ID)
+		if err != nil {
+			stdoutEvents.emit(StreamEvent{TaskID: msg.ID, Event: "output", ErrorCode: "unknown_task", Error: err.Error()})
+			return
+		}
+		stdoutEvents.emit(StreamEvent{TaskID: msg.ID, Event: "output", Chunk: out})
+	}
+}
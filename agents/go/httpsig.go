@@ -0,0 +1,233 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// defaultSignedHeaders is the header set the agent requires a remote
+// submitter to sign: the request line itself, the date, a body digest,
+// and the content type, so a replayed or tampered request is rejected.
+const defaultSignedHeaders = "(request-target) date digest content-type"
+
+const maxDateSkew = 5 * time.Minute
+
+// httpSignature is a parsed `Signature` header, per the IETF HTTP
+// Signatures draft used to authenticate remote task submissions.
+type httpSignature struct {
+	KeyID     string
+	Algorithm string
+	Headers   []string
+	Signature []byte
+}
+
+func parseSignatureHeader(h string) (*httpSignature, error) {
+	sig := &httpSignature{}
+	for _, part := range strings.Split(h, ",") {
+		kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		val := strings.Trim(kv[1], `"`)
+		switch kv[0] {
+		case "keyId":
+			sig.KeyID = val
+		case "algorithm":
+			sig.Algorithm = val
+		case "headers":
+			sig.Headers = strings.Fields(val)
+		case "signature":
+			decoded, err := base64.StdEncoding.DecodeString(val)
+			if err != nil {
+				return nil, fmt.Errorf("decode signature: %w", err)
+			}
+			sig.Signature = decoded
+		}
+	}
+	if sig.KeyID == "" || len(sig.Signature) == 0 {
+		return nil, fmt.Errorf("signature header missing keyId or signature")
+	}
+	if len(sig.Headers) == 0 {
+		sig.Headers = []string{"date"}
+	}
+	return sig, nil
+}
+
+// signingString builds the exact byte sequence that is signed or verified,
+// following the header list negotiated in the Signature header.
+func signingString(req *http.Request, headers []string, digest string) (string, error) {
+	lines := make([]string, 0, len(headers))
+	for _, h := range headers {
+		switch h {
+		case "(request-target)":
+			lines = append(lines, fmt.Sprintf("(request-target): %s %s", strings.ToLower(req.Method), req.URL.RequestURI()))
+		case "digest":
+			lines = append(lines, "digest: "+digest)
+		default:
+			v := req.Header.Get(h)
+			if v == "" {
+				return "", fmt.Errorf("missing header %q required by signature", h)
+			}
+			lines = append(lines, strings.ToLower(h)+": "+v)
+		}
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+func computeDigest(body []byte) string {
+	sum := sha256.Sum256(body)
+	return "SHA-256=" + base64.StdEncoding.EncodeToString(sum[:])
+}
+
+func verifyDateHeader(dateHeader string) error {
+	t, err := http.ParseTime(dateHeader)
+	if err != nil {
+		return fmt.Errorf("invalid Date header: %w", err)
+	}
+	skew := time.Since(t)
+	if skew < 0 {
+		skew = -skew
+	}
+	if skew > maxDateSkew {
+		return fmt.Errorf("date header skew %s exceeds allowed %s", skew, maxDateSkew)
+	}
+	return nil
+}
+
+// verifyRequest authenticates an inbound task submission: it checks the
+// Digest header against the actual body, checks Date freshness, and
+// verifies the Signature header against pub.
+func verifyRequest(req *http.Request, body []byte, pub crypto.PublicKey) error {
+	sigHeader := req.Header.Get("Signature")
+	if sigHeader == "" {
+		return fmt.Errorf("missing Signature header")
+	}
+	sig, err := parseSignatureHeader(sigHeader)
+	if err != nil {
+		return err
+	}
+
+	digestHeader := req.Header.Get("Digest")
+	if digestHeader == "" {
+		return fmt.Errorf("missing Digest header")
+	}
+	if digestHeader != computeDigest(body) {
+		return fmt.Errorf("digest does not match request body")
+	}
+
+	dateHeader := req.Header.Get("Date")
+	if dateHeader == "" {
+		return fmt.Errorf("missing Date header")
+	}
+	if err := verifyDateHeader(dateHeader); err != nil {
+		return err
+	}
+
+	if err := requireSignedHeaders(sig.Headers); err != nil {
+		return err
+	}
+
+	signed, err := signingString(req, sig.Headers, digestHeader)
+	if err != nil {
+		return err
+	}
+	return verifySignatureBytes(pub, []byte(signed), sig.Signature)
+}
+
+// requireSignedHeaders rejects a Signature header whose signer-chosen
+// Headers list omits any of defaultSignedHeaders. Without this, a client
+// could sign only "(request-target)" and leave Date and Digest unsigned;
+// verifyRequest checks those headers' values against the request, but
+// nothing would stop an attacker from replaying an old signed request line
+// paired with a fresh Date/Digest, since only what is listed in Headers is
+// covered by the signature itself.
+func requireSignedHeaders(headers []string) error {
+	signed := make(map[string]bool, len(headers))
+	for _, h := range headers {
+		signed[strings.ToLower(h)] = true
+	}
+	for _, required := range strings.Fields(defaultSignedHeaders) {
+		if !signed[required] {
+			return fmt.Errorf("signature does not cover required header %q", required)
+		}
+	}
+	return nil
+}
+
+func verifySignatureBytes(pub crypto.PublicKey, signed, sig []byte) error {
+	switch key := pub.(type) {
+	case ed25519.PublicKey:
+		if !ed25519.Verify(key, signed, sig) {
+			return fmt.Errorf("ed25519 signature verification failed")
+		}
+		return nil
+	case *rsa.PublicKey:
+		hashed := sha256.Sum256(signed)
+		if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, hashed[:], sig); err != nil {
+			return fmt.Errorf("rsa signature verification failed: %w", err)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported public key type %T", pub)
+	}
+}
+
+// signBytes signs data with signer, returning the raw signature and the
+// HTTP-signature algorithm name it corresponds to.
+func signBytes(signer crypto.Signer, data []byte) (sig []byte, algorithm string, err error) {
+	switch key := signer.(type) {
+	case ed25519.PrivateKey:
+		return ed25519.Sign(key, data), "ed25519", nil
+	case *rsa.PrivateKey:
+		hashed := sha256.Sum256(data)
+		sig, err = rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, hashed[:])
+		return sig, "rsa-sha256", err
+	default:
+		return nil, "", fmt.Errorf("unsupported private key type %T", signer)
+	}
+}
+
+func loadPublicKey(path string) (crypto.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM block found in %s", path)
+	}
+	return x509.ParsePKIXPublicKey(block.Bytes)
+}
+
+func loadPrivateKey(path string) (crypto.Signer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM block found in %s", path)
+	}
+	if key, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("key in %s does not support signing", path)
+		}
+		return signer, nil
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unsupported private key format in %s", path)
+}
@@ -0,0 +1,34 @@
+//go:build linux
+
+package main
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// applyRlimits constrains an already-started child process's CPU and
+// memory use via prlimit(2), targeting pid directly rather than the
+// agent's own process. syscall.Setrlimit only has a self/whole-process
+// variant, and the agent's process is shared by every concurrently
+// running task, so using it here would clamp the agent itself (and one
+// task's limit would leak into another's). There is an inherent, short
+// race between cmd.Start() returning and this call landing where the
+// child runs unconstrained; Go's exec package has no pre-exec hook to
+// close it without cgo. The stdlib syscall package has no Prlimit
+// wrapper (only golang.org/x/sys/unix does, which isn't vendored here),
+// so this calls prlimit64 directly.
+func applyRlimits(pid int, cp *CommandPolicy) {
+	if cp.CPUSeconds > 0 {
+		prlimit(pid, syscall.RLIMIT_CPU, &syscall.Rlimit{Cur: cp.CPUSeconds, Max: cp.CPUSeconds})
+	}
+	if cp.MemoryBytes > 0 {
+		prlimit(pid, syscall.RLIMIT_AS, &syscall.Rlimit{Cur: cp.MemoryBytes, Max: cp.MemoryBytes})
+	}
+}
+
+func prlimit(pid int, resource int, new *syscall.Rlimit) {
+	syscall.Syscall6(syscall.SYS_PRLIMIT64,
+		uintptr(pid), uintptr(resource),
+		uintptr(unsafe.Pointer(new)), 0, 0, 0)
+}
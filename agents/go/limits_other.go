@@ -0,0 +1,7 @@
+//go:build !linux
+
+package main
+
+// applyRlimits is a no-op on platforms without rlimit support; CPU/memory
+// limits are silently not enforced.
+func applyRlimits(pid int, cp *CommandPolicy) {}
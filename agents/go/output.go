@@ -0,0 +1,36 @@
+package main
+
+import "sync"
+
+// outputLimiter enforces a shared byte budget across the stdout and stderr
+// streams of a single task so a command can't be allowed to pipe
+// unbounded output back through the agent.
+type outputLimiter struct {
+	mu        sync.Mutex
+	max       int64 // <= 0 means unlimited
+	used      int64
+	truncated bool
+}
+
+// allow reports whether a chunk of n bytes is still within budget. Once the
+// budget is exhausted it keeps returning false (and truncated=true) so the
+// caller can stop emitting further chunks while still draining the pipe.
+func (l *outputLimiter) allow(n int) (ok bool, truncated bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.max <= 0 {
+		return true, false
+	}
+	if l.truncated || l.used+int64(n) > l.max {
+		l.truncated = true
+		return false, true
+	}
+	l.used += int64(n)
+	return true, false
+}
+
+func (l *outputLimiter) isTruncated() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.truncated
+}
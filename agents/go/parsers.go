@@ -0,0 +1,176 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// lineParser turns one line of command output into a structured value to
+// attach to its stream event, turning the agent into a generic collector
+// (akin to Telegraf's exec plugin) instead of leaving every caller to
+// write its own ad-hoc parsing.
+type lineParser func(line string) (interface{}, error)
+
+// parserFor resolves an AgentTask's "parser" field (plus any parser_args)
+// into the lineParser that should run over its output.
+func parserFor(name string, args map[string]string) (lineParser, error) {
+	switch name {
+	case "":
+		return nil, nil
+	case "json":
+		return parseJSONLine, nil
+	case "influx-line":
+		return parseInfluxLine, nil
+	case "regex":
+		pattern, ok := args["pattern"]
+		if !ok {
+			return nil, fmt.Errorf(`regex parser requires a "pattern" arg`)
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("compile regex pattern: %w", err)
+		}
+		return func(line string) (interface{}, error) { return parseRegexLine(re, line) }, nil
+	case "test2json":
+		return parseTest2JSONLine, nil
+	default:
+		return nil, fmt.Errorf("unknown parser %q", name)
+	}
+}
+
+// parseJSONLine unmarshals one line of output as a JSON value. It is
+// line-scoped (JSON-lines/NDJSON), matching every other parser here, which
+// all run once per line via streamLines: a command that writes a single
+// JSON document spread across multiple lines will fail to parse on each of
+// them and attach nothing. Point commands that emit one JSON object per
+// line (or pass `-compact`/`jq -c`/equivalent) at this parser.
+func parseJSONLine(line string) (interface{}, error) {
+	var v interface{}
+	if err := json.Unmarshal([]byte(line), &v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+func parseRegexLine(re *regexp.Regexp, line string) (interface{}, error) {
+	match := re.FindStringSubmatch(line)
+	if match == nil {
+		return nil, fmt.Errorf("line does not match pattern")
+	}
+	groups := make(map[string]string)
+	for i, name := range re.SubexpNames() {
+		if i == 0 || name == "" {
+			continue
+		}
+		groups[name] = match[i]
+	}
+	return groups, nil
+}
+
+// InfluxPoint is one parsed InfluxDB line-protocol metric:
+// measurement,tag=value field=value timestamp
+type InfluxPoint struct {
+	Name      string                 `json:"name"`
+	Tags      map[string]string      `json:"tags,omitempty"`
+	Fields    map[string]interface{} `json:"fields"`
+	Timestamp int64                  `json:"timestamp,omitempty"`
+}
+
+func parseInfluxLine(line string) (interface{}, error) {
+	parts := strings.Fields(line)
+	if len(parts) < 2 {
+		return nil, fmt.Errorf("invalid influx line protocol: %q", line)
+	}
+	nameAndTags := strings.Split(parts[0], ",")
+	point := InfluxPoint{Name: nameAndTags[0], Fields: map[string]interface{}{}}
+	if len(nameAndTags) > 1 {
+		point.Tags = make(map[string]string, len(nameAndTags)-1)
+		for _, tag := range nameAndTags[1:] {
+			if kv := strings.SplitN(tag, "=", 2); len(kv) == 2 {
+				point.Tags[kv[0]] = kv[1]
+			}
+		}
+	}
+	for _, field := range strings.Split(parts[1], ",") {
+		kv := strings.SplitN(field, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		point.Fields[kv[0]] = parseInfluxFieldValue(kv[1])
+	}
+	if len(parts) >= 3 {
+		if ts, err := strconv.ParseInt(parts[2], 10, 64); err == nil {
+			point.Timestamp = ts
+		}
+	}
+	return point, nil
+}
+
+func parseInfluxFieldValue(raw string) interface{} {
+	if trimmed := strings.TrimSuffix(raw, "i"); trimmed != raw {
+		if n, err := strconv.ParseInt(trimmed, 10, 64); err == nil {
+			return n
+		}
+	}
+	switch raw {
+	case "true", "t", "T", "TRUE", "True":
+		return true
+	case "false", "f", "F", "FALSE", "False":
+		return false
+	}
+	if f, err := strconv.ParseFloat(raw, 64); err == nil {
+		return f
+	}
+	return strings.Trim(raw, `"`)
+}
+
+// Test2JSONEvent mirrors one event of Go's `go test -json` / test2json
+// stream, field names included, so downstream tooling that already speaks
+// test2json can consume `go test -v` output collected through the agent.
+type Test2JSONEvent struct {
+	Action  string  `json:"Action"`
+	Test    string  `json:"Test,omitempty"`
+	Elapsed float64 `json:"Elapsed,omitempty"`
+	Output  string  `json:"Output,omitempty"`
+}
+
+func parseTest2JSONLine(line string) (interface{}, error) {
+	switch {
+	case strings.HasPrefix(line, "=== RUN   "):
+		return Test2JSONEvent{Action: "run", Test: strings.TrimSpace(strings.TrimPrefix(line, "=== RUN   "))}, nil
+	case strings.HasPrefix(line, "=== PAUSE "):
+		return Test2JSONEvent{Action: "pause", Test: strings.TrimSpace(strings.TrimPrefix(line, "=== PAUSE "))}, nil
+	case strings.HasPrefix(line, "=== CONT  "):
+		return Test2JSONEvent{Action: "cont", Test: strings.TrimSpace(strings.TrimPrefix(line, "=== CONT  "))}, nil
+	case strings.HasPrefix(line, "--- PASS: "), strings.HasPrefix(line, "--- FAIL: "), strings.HasPrefix(line, "--- SKIP: "):
+		return parseTest2JSONResult(line)
+	default:
+		return Test2JSONEvent{Action: "output", Output: line}, nil
+	}
+}
+
+func parseTest2JSONResult(line string) (interface{}, error) {
+	var action, rest string
+	switch {
+	case strings.HasPrefix(line, "--- PASS: "):
+		action, rest = "pass", strings.TrimPrefix(line, "--- PASS: ")
+	case strings.HasPrefix(line, "--- FAIL: "):
+		action, rest = "fail", strings.TrimPrefix(line, "--- FAIL: ")
+	case strings.HasPrefix(line, "--- SKIP: "):
+		action, rest = "skip", strings.TrimPrefix(line, "--- SKIP: ")
+	}
+
+	name := rest
+	var elapsed float64
+	if idx := strings.LastIndex(rest, "("); idx >= 0 {
+		name = strings.TrimSpace(rest[:idx])
+		durStr := strings.TrimSuffix(strings.TrimSuffix(rest[idx+1:], ")"), "s")
+		if f, err := strconv.ParseFloat(durStr, 64); err == nil {
+			elapsed = f
+		}
+	}
+	return Test2JSONEvent{Action: action, Test: name, Elapsed: elapsed}, nil
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"reflect"
+	"regexp"
+	"testing"
+)
+
+func TestParseJSONLine(t *testing.T) {
+	v, err := parseJSONLine(`{"a":1,"b":"x"}`)
+	if err != nil {
+		t.Fatalf("parseJSONLine: %v", err)
+	}
+	m, ok := v.(map[string]interface{})
+	if !ok || m["a"] != 1.0 || m["b"] != "x" {
+		t.Fatalf("unexpected parsed value: %#v", v)
+	}
+}
+
+func TestParseJSONLineRejectsInvalidJSON(t *testing.T) {
+	if _, err := parseJSONLine("not json"); err == nil {
+		t.Fatal("expected an error for invalid JSON")
+	}
+}
+
+func TestParseInfluxLine(t *testing.T) {
+	v, err := parseInfluxLine(`cpu,host=a usage=1.5,count=3i,ok=true 1700000000`)
+	if err != nil {
+		t.Fatalf("parseInfluxLine: %v", err)
+	}
+	point, ok := v.(InfluxPoint)
+	if !ok {
+		t.Fatalf("expected InfluxPoint, got %#v", v)
+	}
+	if point.Name != "cpu" {
+		t.Errorf("name = %q, want cpu", point.Name)
+	}
+	if point.Tags["host"] != "a" {
+		t.Errorf("tags[host] = %q, want a", point.Tags["host"])
+	}
+	if point.Fields["usage"] != 1.5 {
+		t.Errorf("fields[usage] = %#v, want 1.5", point.Fields["usage"])
+	}
+	if point.Fields["count"] != int64(3) {
+		t.Errorf("fields[count] = %#v, want int64(3)", point.Fields["count"])
+	}
+	if point.Fields["ok"] != true {
+		t.Errorf("fields[ok] = %#v, want true", point.Fields["ok"])
+	}
+	if point.Timestamp != 1700000000 {
+		t.Errorf("timestamp = %d, want 1700000000", point.Timestamp)
+	}
+}
+
+func TestParseInfluxLineRejectsMalformed(t *testing.T) {
+	if _, err := parseInfluxLine("just-one-token"); err == nil {
+		t.Fatal("expected an error for a line with no field set")
+	}
+}
+
+func TestParseRegexLine(t *testing.T) {
+	re := regexp.MustCompile(`^(?P<level>\w+): (?P<msg>.*)$`)
+	v, err := parseRegexLine(re, "ERROR: disk full")
+	if err != nil {
+		t.Fatalf("parseRegexLine: %v", err)
+	}
+	groups, ok := v.(map[string]string)
+	if !ok {
+		t.Fatalf("expected map[string]string, got %#v", v)
+	}
+	want := map[string]string{"level": "ERROR", "msg": "disk full"}
+	if !reflect.DeepEqual(groups, want) {
+		t.Errorf("groups = %#v, want %#v", groups, want)
+	}
+}
+
+func TestParseRegexLineRejectsNonMatch(t *testing.T) {
+	re := regexp.MustCompile(`^(?P<level>\w+): (?P<msg>.*)$`)
+	if _, err := parseRegexLine(re, "not formatted like that"); err == nil {
+		t.Fatal("expected an error when the line doesn't match")
+	}
+}
+
+func TestParseTest2JSONLine(t *testing.T) {
+	cases := map[string]Test2JSONEvent{
+		"=== RUN   TestFoo":         {Action: "run", Test: "TestFoo"},
+		"--- PASS: TestFoo (0.01s)": {Action: "pass", Test: "TestFoo", Elapsed: 0.01},
+		"--- FAIL: TestBar (1.50s)": {Action: "fail", Test: "TestBar", Elapsed: 1.5},
+		"some plain log output":     {Action: "output", Output: "some plain log output"},
+	}
+	for line, want := range cases {
+		v, err := parseTest2JSONLine(line)
+		if err != nil {
+			t.Fatalf("parseTest2JSONLine(%q): %v", line, err)
+		}
+		got, ok := v.(Test2JSONEvent)
+		if !ok || got != want {
+			t.Errorf("parseTest2JSONLine(%q) = %#v, want %#v", line, v, want)
+		}
+	}
+}
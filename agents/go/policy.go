@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+)
+
+// CommandPolicy describes the constraints applied to a single allow-listed
+// command.
+type CommandPolicy struct {
+	ArgPattern     string `json:"arg_pattern,omitempty"`
+	WorkDir        string `json:"work_dir,omitempty"`
+	Chroot         string `json:"chroot,omitempty"`
+	MaxOutputBytes int64  `json:"max_output_bytes,omitempty"`
+	WallTimeMs     int64  `json:"wall_time_ms,omitempty"`
+	CPUSeconds     uint64 `json:"cpu_seconds,omitempty"`
+	MemoryBytes    uint64 `json:"memory_bytes,omitempty"`
+
+	argRegexp *regexp.Regexp
+}
+
+// Policy is the top-level allow-list config loaded from the path given by
+// -policy or the TERMUX_ULTRA_AGENT_POLICY environment variable. When no
+// policy is configured the agent falls back to its historical
+// run-anything behavior.
+type Policy struct {
+	Commands map[string]CommandPolicy `json:"commands"`
+	EnvAllow []string                 `json:"env_allow,omitempty"`
+}
+
+const (
+	errCodePolicyDenied = "policy_denied"
+	errCodePolicyConfig = "policy_config_error"
+)
+
+func loadPolicy(path string) (*Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read policy file: %w", err)
+	}
+	var p Policy
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("parse policy file: %w", err)
+	}
+	for name, cp := range p.Commands {
+		if cp.ArgPattern != "" {
+			re, err := regexp.Compile(cp.ArgPattern)
+			if err != nil {
+				return nil, fmt.Errorf("compile arg_pattern for %q: %w", name, err)
+			}
+			cp.argRegexp = re
+			p.Commands[name] = cp
+		}
+	}
+	return &p, nil
+}
+
+// authorize checks a task against the policy, returning the matched
+// CommandPolicy or an error describing why the task was denied.
+func (p *Policy) authorize(task AgentTask) (*CommandPolicy, error) {
+	cp, ok := p.Commands[task.Command]
+	if !ok {
+		return nil, fmt.Errorf("command %q is not allow-listed", task.Command)
+	}
+	if cp.argRegexp != nil {
+		for _, arg := range task.Args {
+			if !cp.argRegexp.MatchString(arg) {
+				return nil, fmt.Errorf("argument %q does not match allowed pattern for %q", arg, task.Command)
+			}
+		}
+	}
+	return &cp, nil
+}
+
+// scrubEnv returns the process environment filtered down to the variables
+// named in EnvAllow. A nil/empty EnvAllow leaves the environment untouched.
+func (p *Policy) scrubEnv() []string {
+	if len(p.EnvAllow) == 0 {
+		return nil
+	}
+	allowed := make(map[string]bool, len(p.EnvAllow))
+	for _, name := range p.EnvAllow {
+		allowed[name] = true
+	}
+	env := os.Environ()
+	out := make([]string, 0, len(env))
+	for _, kv := range env {
+		for name := range allowed {
+			if len(kv) > len(name) && kv[:len(name)+1] == name+"=" {
+				out = append(out, kv)
+				break
+			}
+		}
+	}
+	return out
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPolicyAuthorizeAllowsListedCommand(t *testing.T) {
+	p := &Policy{Commands: map[string]CommandPolicy{
+		"echo": {},
+	}}
+	if _, err := p.authorize(AgentTask{Command: "echo"}); err != nil {
+		t.Fatalf("expected echo to be authorized, got %v", err)
+	}
+}
+
+func TestPolicyAuthorizeDeniesUnlistedCommand(t *testing.T) {
+	p := &Policy{Commands: map[string]CommandPolicy{
+		"echo": {},
+	}}
+	if _, err := p.authorize(AgentTask{Command: "rm"}); err == nil {
+		t.Fatal("expected rm to be denied, got nil error")
+	}
+}
+
+func TestPolicyAuthorizeEnforcesArgPattern(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policy.json")
+	contents := `{"commands":{"ls":{"arg_pattern":"^[-\\w./]*$"}}}`
+	if err := os.WriteFile(path, []byte(contents), 0o644); err != nil {
+		t.Fatalf("write policy file: %v", err)
+	}
+
+	p, err := loadPolicy(path)
+	if err != nil {
+		t.Fatalf("loadPolicy: %v", err)
+	}
+
+	if _, err := p.authorize(AgentTask{Command: "ls", Args: []string{"-la", "./tmp"}}); err != nil {
+		t.Fatalf("expected matching args to be authorized, got %v", err)
+	}
+	if _, err := p.authorize(AgentTask{Command: "ls", Args: []string{"; rm -rf /"}}); err == nil {
+		t.Fatal("expected an argument violating the pattern to be denied")
+	}
+}
+
+func TestPolicyScrubEnvFiltersToAllowList(t *testing.T) {
+	t.Setenv("TERMUX_ULTRA_TEST_ALLOWED", "yes")
+	t.Setenv("TERMUX_ULTRA_TEST_BLOCKED", "no")
+
+	p := &Policy{EnvAllow: []string{"TERMUX_ULTRA_TEST_ALLOWED"}}
+	env := p.scrubEnv()
+
+	var sawAllowed, sawBlocked bool
+	for _, kv := range env {
+		if kv == "TERMUX_ULTRA_TEST_ALLOWED=yes" {
+			sawAllowed = true
+		}
+		if len(kv) >= len("TERMUX_ULTRA_TEST_BLOCKED=") && kv[:len("TERMUX_ULTRA_TEST_BLOCKED=")] == "TERMUX_ULTRA_TEST_BLOCKED=" {
+			sawBlocked = true
+		}
+	}
+	if !sawAllowed {
+		t.Error("expected allow-listed env var to survive scrubbing")
+	}
+	if sawBlocked {
+		t.Error("expected non-allow-listed env var to be scrubbed")
+	}
+}
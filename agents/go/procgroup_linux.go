@@ -0,0 +1,49 @@
+//go:build linux
+
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+	"syscall"
+)
+
+// setProcessGroup arranges for cmd's child to become the leader of its own
+// process group, so the whole tree it spawns can be signaled together.
+func setProcessGroup(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setpgid = true
+}
+
+// killProcessGroup delivers sig to every process in cmd's group rather than
+// just the direct child, so shells and their children don't leak.
+func killProcessGroup(cmd *exec.Cmd, sig string) error {
+	if cmd.Process == nil {
+		return fmt.Errorf("process not started")
+	}
+	pgid, err := syscall.Getpgid(cmd.Process.Pid)
+	if err != nil {
+		return err
+	}
+	return syscall.Kill(-pgid, signalFromName(sig))
+}
+
+func signalFromName(name string) syscall.Signal {
+	switch strings.TrimPrefix(strings.ToUpper(name), "SIG") {
+	case "KILL":
+		return syscall.SIGKILL
+	case "INT":
+		return syscall.SIGINT
+	case "HUP":
+		return syscall.SIGHUP
+	case "QUIT":
+		return syscall.SIGQUIT
+	case "TERM", "":
+		return syscall.SIGTERM
+	default:
+		return syscall.SIGTERM
+	}
+}
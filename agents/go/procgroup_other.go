@@ -0,0 +1,19 @@
+//go:build !linux
+
+package main
+
+import (
+	"fmt"
+	"os/exec"
+)
+
+// setProcessGroup is a no-op on platforms without process groups; signals
+// reach only the direct child.
+func setProcessGroup(cmd *exec.Cmd) {}
+
+func killProcessGroup(cmd *exec.Cmd, sig string) error {
+	if cmd.Process == nil {
+		return fmt.Errorf("process not started")
+	}
+	return cmd.Process.Kill()
+}
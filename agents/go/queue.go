@@ -0,0 +1,353 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TaskState is where a queued task sits in its life cycle. Android
+// aggressively kills background processes, so the agent persists this
+// before running anything in order to notice work that got interrupted.
+type TaskState string
+
+const (
+	StateQueued      TaskState = "queued"
+	StateRunning     TaskState = "running"
+	StateDone        TaskState = "done"
+	StateFailed      TaskState = "failed"
+	StateInterrupted TaskState = "interrupted"
+	StateCanceled    TaskState = "canceled"
+)
+
+// RetryPolicy is the optional "retry" field on an AgentTask: how many
+// attempts a durably-queued task gets and how long to wait between them.
+type RetryPolicy struct {
+	Max       int   `json:"max"`
+	BackoffMs int64 `json:"backoff_ms"`
+}
+
+// maxInlineOutput is the output size above which a task's combined
+// stdout/stderr is spilled to its own file instead of living in the
+// task's record.
+const maxInlineOutput = 64 * 1024
+
+// errCodeInvalidTaskID is the StreamEvent.ErrorCode used when a task's ID
+// fails validTaskID and is rejected before it ever reaches the queue's
+// on-disk paths.
+const errCodeInvalidTaskID = "invalid_task_id"
+
+// queueRecord is the durable, on-disk state of one task. The request that
+// introduced this queue asked for a SQLite- or BoltDB-backed store; neither
+// is vendored in this tree (there is no go.mod and nothing else here pulls
+// in third-party packages), so this is a deliberate downgrade to one
+// JSON file per task under the queue directory instead. The state machine
+// and recovery semantics are identical either way, and save() writes each
+// record atomically, so the deviation costs lookup performance at large
+// queue sizes, not durability.
+type queueRecord struct {
+	Task         AgentTask    `json:"task"`
+	State        TaskState    `json:"state"`
+	Attempt      int          `json:"attempt"`
+	UpdatedAt    string       `json:"updated_at"`
+	Result       *StreamEvent `json:"result,omitempty"`
+	OutputInline string       `json:"output_inline,omitempty"`
+	OutputPath   string       `json:"output_path,omitempty"`
+}
+
+type durableQueue struct {
+	mu  sync.Mutex
+	dir string
+}
+
+func openQueue(dir string) (*durableQueue, error) {
+	if err := os.MkdirAll(filepath.Join(dir, "output"), 0o755); err != nil {
+		return nil, fmt.Errorf("create queue directory: %w", err)
+	}
+	return &durableQueue{dir: dir}, nil
+}
+
+// validTaskID rejects task IDs that could escape the queue directory when
+// joined into a file path: empty IDs, anything containing a path separator
+// or "..", and (as a backstop for either) any ID that doesn't round-trip
+// through filepath.Base unchanged. Task IDs arrive straight from caller-
+// submitted JSON (stdin or, with chunk0-4, the authenticated -listen HTTP
+// endpoint), so recordPath/outputPath must never join one in unchecked.
+func validTaskID(id string) error {
+	if id == "" {
+		return fmt.Errorf("task id must not be empty")
+	}
+	if strings.ContainsAny(id, `/\`) || strings.Contains(id, "..") || filepath.Base(id) != id {
+		return fmt.Errorf("invalid task id %q: must not contain path separators or \"..\"", id)
+	}
+	return nil
+}
+
+func (q *durableQueue) recordPath(id string) (string, error) {
+	if err := validTaskID(id); err != nil {
+		return "", err
+	}
+	return filepath.Join(q.dir, id+".json"), nil
+}
+
+func (q *durableQueue) outputPath(id string) (string, error) {
+	if err := validTaskID(id); err != nil {
+		return "", err
+	}
+	return filepath.Join(q.dir, "output", id+".log"), nil
+}
+
+// save persists rec via a temp file + rename so a crash mid-write (the
+// scenario this queue exists to survive) can never leave a truncated
+// record on disk for load/all to silently skip.
+func (q *durableQueue) save(rec *queueRecord) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	path, err := q.recordPath(rec.Task.ID)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(rec, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+func (q *durableQueue) load(id string) (*queueRecord, error) {
+	path, err := q.recordPath(id)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var rec queueRecord
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return nil, err
+	}
+	return &rec, nil
+}
+
+func (q *durableQueue) all() ([]*queueRecord, error) {
+	entries, err := os.ReadDir(q.dir)
+	if err != nil {
+		return nil, err
+	}
+	var out []*queueRecord
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".json") {
+			continue
+		}
+		rec, err := q.load(strings.TrimSuffix(e.Name(), ".json"))
+		if err != nil {
+			continue
+		}
+		out = append(out, rec)
+	}
+	return out, nil
+}
+
+// since returns every record last updated at or after ts (RFC3339). An
+// empty ts returns full history.
+func (q *durableQueue) since(ts string) ([]*queueRecord, error) {
+	recs, err := q.all()
+	if err != nil {
+		return nil, err
+	}
+	if ts == "" {
+		return recs, nil
+	}
+	cutoff, err := time.Parse(time.RFC3339Nano, ts)
+	if err != nil {
+		return nil, fmt.Errorf("parse since timestamp: %w", err)
+	}
+	out := recs[:0]
+	for _, r := range recs {
+		updated, err := time.Parse(time.RFC3339Nano, r.UpdatedAt)
+		if err != nil {
+			continue
+		}
+		if !updated.Before(cutoff) {
+			out = append(out, r)
+		}
+	}
+	return out, nil
+}
+
+// output returns the recorded combined stdout/stderr for a task, reading
+// it back from the spill file if it was too large to stay inline.
+func (q *durableQueue) output(id string) (string, error) {
+	rec, err := q.load(id)
+	if err != nil {
+		return "", err
+	}
+	if rec.OutputPath == "" {
+		return rec.OutputInline, nil
+	}
+	data, err := os.ReadFile(rec.OutputPath)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+func nowStamp() string {
+	return time.Now().UTC().Format(time.RFC3339Nano)
+}
+
+// runTask drives task through the durable queue's state machine around a
+// single streamTaskTo call, persisting state before and after, recording
+// output, and retrying per task.Retry on failure.
+func (q *durableQueue) runTask(task AgentTask, policy *Policy, heartbeat time.Duration, reg *registry, emit func(StreamEvent)) {
+	q.runTaskFrom(task, policy, heartbeat, reg, emit, 1)
+}
+
+// runTaskFrom is runTask starting at startAttempt instead of 1, so a task
+// recovered by recoverInterrupted resumes its retry budget rather than
+// getting a fresh one.
+func (q *durableQueue) runTaskFrom(task AgentTask, policy *Policy, heartbeat time.Duration, reg *registry, emit func(StreamEvent), startAttempt int) {
+	if err := validTaskID(task.ID); err != nil {
+		emit(StreamEvent{TaskID: task.ID, Event: "exit", ExitResult: &ExitResult{}, Error: err.Error(), ErrorCode: errCodeInvalidTaskID})
+		return
+	}
+
+	rec := &queueRecord{Task: task, State: StateQueued, UpdatedAt: nowStamp()}
+	q.save(rec)
+
+	maxAttempts := 1
+	var backoff time.Duration
+	if task.Retry != nil && task.Retry.Max > maxAttempts {
+		maxAttempts = task.Retry.Max
+		backoff = time.Duration(task.Retry.BackoffMs) * time.Millisecond
+	}
+
+	for attempt := startAttempt; ; attempt++ {
+		rec.State, rec.Attempt, rec.UpdatedAt = StateRunning, attempt, nowStamp()
+		q.save(rec)
+
+		// task.ID was already validated above, so this can't fail.
+		outPath, _ := q.outputPath(task.ID)
+		recorder := newOutputRecorder(outPath, maxInlineOutput)
+		var exitEvent StreamEvent
+		streamTaskTo(task, policy, heartbeat, reg, func(ev StreamEvent) {
+			if ev.Event == "stdout" || ev.Event == "stderr" {
+				recorder.append(ev.Chunk)
+			}
+			if ev.Event == "exit" {
+				exitEvent = ev
+			}
+			emit(ev)
+		})
+
+		rec.Result = &exitEvent
+		rec.OutputInline, rec.OutputPath = recorder.finalize()
+		if exitEvent.ExitResult != nil && exitEvent.Success {
+			rec.State, rec.UpdatedAt = StateDone, nowStamp()
+			q.save(rec)
+			return
+		}
+
+		// A user-triggered cancel (see control.go's "cancel") is not an
+		// ordinary failure: retrying it would silently undo the cancel, so
+		// stop here instead of falling into the retry/backoff path below.
+		if exitEvent.ErrorCode == errCodeCanceled {
+			rec.State, rec.UpdatedAt = StateCanceled, nowStamp()
+			q.save(rec)
+			return
+		}
+
+		rec.State, rec.UpdatedAt = StateFailed, nowStamp()
+		q.save(rec)
+		if attempt >= maxAttempts {
+			return
+		}
+		if backoff > 0 {
+			time.Sleep(backoff)
+		}
+	}
+}
+
+// recoverInterrupted marks every task left in "running" state from a
+// previous run as interrupted (the process was killed mid-task) and, for
+// tasks with retry budget remaining, hands them back to dispatch to run
+// again starting at the next attempt, rather than resetting the attempt
+// counter and granting a fresh retry budget.
+func (q *durableQueue) recoverInterrupted(dispatch func(AgentTask, int)) error {
+	recs, err := q.all()
+	if err != nil {
+		return err
+	}
+	for _, rec := range recs {
+		if rec.State != StateRunning {
+			continue
+		}
+		rec.State, rec.UpdatedAt = StateInterrupted, nowStamp()
+		q.save(rec)
+		if rec.Task.Retry != nil && rec.Attempt < rec.Task.Retry.Max {
+			dispatch(rec.Task, rec.Attempt+1)
+		}
+	}
+	return nil
+}
+
+// outputRecorder accumulates a task's combined stdout/stderr chunks,
+// spilling to disk once they exceed max bytes instead of growing an
+// unbounded in-memory buffer.
+type outputRecorder struct {
+	mu   sync.Mutex
+	max  int64
+	buf  []byte
+	file *os.File
+	path string
+}
+
+func newOutputRecorder(path string, max int64) *outputRecorder {
+	return &outputRecorder{path: path, max: max}
+}
+
+func (o *outputRecorder) append(chunk string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	line := append([]byte(chunk), '\n')
+	if o.file != nil {
+		o.file.Write(line)
+		return
+	}
+	if int64(len(o.buf))+int64(len(line)) > o.max {
+		f, err := os.Create(o.path)
+		if err != nil {
+			return
+		}
+		f.Write(o.buf)
+		f.Write(line)
+		o.file = f
+		return
+	}
+	o.buf = append(o.buf, line...)
+}
+
+// finalize closes any spill file and returns either the inline content or
+// the path it was spilled to, never both.
+func (o *outputRecorder) finalize() (inline string, spilledPath string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.file != nil {
+		o.file.Close()
+		return "", o.path
+	}
+	return string(o.buf), ""
+}
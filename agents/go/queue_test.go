@@ -0,0 +1,245 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestQueue(t *testing.T) *durableQueue {
+	t.Helper()
+	q, err := openQueue(t.TempDir())
+	if err != nil {
+		t.Fatalf("openQueue: %v", err)
+	}
+	return q
+}
+
+func TestQueueSaveLoadRoundTrip(t *testing.T) {
+	q := newTestQueue(t)
+	rec := &queueRecord{
+		Task:      AgentTask{ID: "t1", Command: "echo"},
+		State:     StateDone,
+		Attempt:   2,
+		UpdatedAt: nowStamp(),
+	}
+	if err := q.save(rec); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+
+	got, err := q.load("t1")
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if got.State != StateDone || got.Attempt != 2 || got.Task.Command != "echo" {
+		t.Fatalf("unexpected record after round-trip: %#v", got)
+	}
+
+	if entries, err := filepath.Glob(filepath.Join(q.dir, "*.tmp")); err != nil || len(entries) != 0 {
+		t.Fatalf("expected save's temp file to be renamed away, found %v (err %v)", entries, err)
+	}
+}
+
+func TestQueueSaveRejectsPathTraversalID(t *testing.T) {
+	q := newTestQueue(t)
+	for _, id := range []string{"../outside/evil", "..", "a/b", `a\b`, ""} {
+		rec := &queueRecord{Task: AgentTask{ID: id, Command: "echo"}, State: StateDone, UpdatedAt: nowStamp()}
+		if err := q.save(rec); err == nil {
+			t.Errorf("save with id %q: expected an error, got nil", id)
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(q.dir, "..", "outside")); !os.IsNotExist(err) {
+		t.Fatalf("save escaped the queue directory: %v", err)
+	}
+}
+
+func TestQueueRunTaskFromRejectsPathTraversalID(t *testing.T) {
+	q := newTestQueue(t)
+	task := AgentTask{ID: "../outside/evil", Command: "echo"}
+
+	var events []StreamEvent
+	q.runTaskFrom(task, nil, 0, nil, func(ev StreamEvent) { events = append(events, ev) }, 1)
+
+	if len(events) != 1 || events[0].Event != "exit" || events[0].ErrorCode != errCodeInvalidTaskID {
+		t.Fatalf("events = %#v, want a single exit event with ErrorCode %q", events, errCodeInvalidTaskID)
+	}
+	if _, err := q.load(task.ID); err == nil {
+		t.Fatal("expected the malicious task id to never have been persisted")
+	}
+}
+
+func TestQueueRunTaskFromStopsRetryingOnCancel(t *testing.T) {
+	q := newTestQueue(t)
+	reg := newRegistry()
+	task := AgentTask{
+		ID:      "t-cancel",
+		Command: "sh",
+		Args:    []string{"-c", "sleep 5"},
+		Retry:   &RetryPolicy{Max: 3, BackoffMs: 1},
+	}
+
+	go func() {
+		for {
+			if h, ok := reg.get(task.ID); ok {
+				h.cancel()
+				return
+			}
+		}
+	}()
+
+	var events []StreamEvent
+	q.runTaskFrom(task, nil, 0, reg, func(ev StreamEvent) { events = append(events, ev) }, 1)
+
+	rec, err := q.load(task.ID)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if rec.State != StateCanceled {
+		t.Errorf("state = %q, want %q", rec.State, StateCanceled)
+	}
+	if rec.Attempt != 1 {
+		t.Errorf("attempt = %d, want 1 (a cancel must not trigger a retry)", rec.Attempt)
+	}
+
+	var sawCanceled bool
+	for _, ev := range events {
+		if ev.Event == "exit" {
+			sawCanceled = ev.ErrorCode == errCodeCanceled
+		}
+	}
+	if !sawCanceled {
+		t.Errorf("events = %#v, want the exit event to carry ErrorCode %q", events, errCodeCanceled)
+	}
+}
+
+func TestQueueRunTaskRetriesAndRecordsFinalState(t *testing.T) {
+	q := newTestQueue(t)
+	task := AgentTask{
+		ID:      "t2",
+		Command: "sh",
+		Args:    []string{"-c", "exit 1"},
+		Retry:   &RetryPolicy{Max: 3, BackoffMs: 1},
+	}
+
+	var events []StreamEvent
+	q.runTask(task, nil, 0, nil, func(ev StreamEvent) { events = append(events, ev) })
+
+	rec, err := q.load(task.ID)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if rec.State != StateFailed {
+		t.Errorf("state = %q, want %q", rec.State, StateFailed)
+	}
+	if rec.Attempt != 3 {
+		t.Errorf("attempt = %d, want 3 (should have exhausted the retry budget)", rec.Attempt)
+	}
+}
+
+func TestQueueRunTaskFromResumesAtGivenAttempt(t *testing.T) {
+	q := newTestQueue(t)
+	task := AgentTask{
+		ID:      "t3",
+		Command: "sh",
+		Args:    []string{"-c", "exit 1"},
+		Retry:   &RetryPolicy{Max: 3, BackoffMs: 1},
+	}
+
+	q.runTaskFrom(task, nil, 0, nil, func(StreamEvent) {}, 3)
+
+	rec, err := q.load(task.ID)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if rec.Attempt != 3 {
+		t.Errorf("attempt = %d, want 3 (started at 3 with no budget left for a retry)", rec.Attempt)
+	}
+	if rec.State != StateFailed {
+		t.Errorf("state = %q, want %q", rec.State, StateFailed)
+	}
+}
+
+func TestQueueRecoverInterruptedResumesFromNextAttempt(t *testing.T) {
+	q := newTestQueue(t)
+	rec := &queueRecord{
+		Task:      AgentTask{ID: "t4", Command: "echo", Retry: &RetryPolicy{Max: 3}},
+		State:     StateRunning,
+		Attempt:   1,
+		UpdatedAt: nowStamp(),
+	}
+	if err := q.save(rec); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+
+	var gotTask AgentTask
+	var gotAttempt int
+	called := false
+	err := q.recoverInterrupted(func(task AgentTask, startAttempt int) {
+		called = true
+		gotTask, gotAttempt = task, startAttempt
+	})
+	if err != nil {
+		t.Fatalf("recoverInterrupted: %v", err)
+	}
+	if !called {
+		t.Fatal("expected recoverInterrupted to dispatch the interrupted task")
+	}
+	if gotTask.ID != "t4" {
+		t.Errorf("dispatched task ID = %q, want t4", gotTask.ID)
+	}
+	if gotAttempt != 2 {
+		t.Errorf("dispatched startAttempt = %d, want 2 (resuming after attempt 1, not resetting to 1)", gotAttempt)
+	}
+
+	got, err := q.load("t4")
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if got.State != StateInterrupted {
+		t.Errorf("state = %q, want %q", got.State, StateInterrupted)
+	}
+}
+
+func TestQueueRecoverInterruptedSkipsExhaustedRetryBudget(t *testing.T) {
+	q := newTestQueue(t)
+	rec := &queueRecord{
+		Task:      AgentTask{ID: "t5", Command: "echo", Retry: &RetryPolicy{Max: 2}},
+		State:     StateRunning,
+		Attempt:   2,
+		UpdatedAt: nowStamp(),
+	}
+	if err := q.save(rec); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+
+	called := false
+	err := q.recoverInterrupted(func(AgentTask, int) { called = true })
+	if err != nil {
+		t.Fatalf("recoverInterrupted: %v", err)
+	}
+	if called {
+		t.Error("expected no dispatch once the retry budget (attempt >= Retry.Max) is exhausted")
+	}
+}
+
+func TestQueueSinceFiltersByTimestamp(t *testing.T) {
+	q := newTestQueue(t)
+	early := nowStamp()
+	time.Sleep(2 * time.Millisecond)
+	cutoff := nowStamp()
+	time.Sleep(2 * time.Millisecond)
+	late := nowStamp()
+
+	q.save(&queueRecord{Task: AgentTask{ID: "old"}, State: StateDone, UpdatedAt: early})
+	q.save(&queueRecord{Task: AgentTask{ID: "new"}, State: StateDone, UpdatedAt: late})
+
+	recs, err := q.since(cutoff)
+	if err != nil {
+		t.Fatalf("since: %v", err)
+	}
+	if len(recs) != 1 || recs[0].Task.ID != "new" {
+		t.Fatalf("since(%q) = %v, want only the record updated after cutoff", cutoff, recs)
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"os/exec"
+	"sync"
+)
+
+// taskHandle is the registry's record of a task currently running (or just
+// finished) so that later control messages can act on it.
+type taskHandle struct {
+	id     string
+	cancel func()
+	cmd    *exec.Cmd
+}
+
+// registry tracks in-flight tasks by ID so control messages (cancel,
+// signal, list) arriving later on stdin can reach them.
+type registry struct {
+	mu    sync.Mutex
+	tasks map[string]*taskHandle
+}
+
+func newRegistry() *registry {
+	return &registry{tasks: make(map[string]*taskHandle)}
+}
+
+func (r *registry) add(h *taskHandle) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.tasks[h.id] = h
+}
+
+func (r *registry) remove(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.tasks, id)
+}
+
+func (r *registry) get(id string) (*taskHandle, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	h, ok := r.tasks[id]
+	return h, ok
+}
+
+func (r *registry) list() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	ids := make([]string, 0, len(r.tasks))
+	for id := range r.tasks {
+		ids = append(ids, id)
+	}
+	return ids
+}
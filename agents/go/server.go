@@ -0,0 +1,101 @@
+package main
+
+import (
+	"crypto"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// maxTaskBodyBytes bounds a submitted task's JSON body. It's applied before
+// signature verification, since an unbounded io.ReadAll would let any caller
+// who can reach the port exhaust memory with an oversized request before
+// authentication ever gets a chance to reject it.
+const maxTaskBodyBytes = 1 << 20 // 1MiB; a task's command/args/parser config is tiny next to this.
+
+// remoteServer accepts AgentTask submissions over HTTP, in place of
+// reading them from stdin, for a controller machine pushing work to a
+// Termux device over the network.
+type remoteServer struct {
+	policy    *Policy
+	reg       *registry
+	heartbeat time.Duration
+	pubKey    crypto.PublicKey
+	queue     *durableQueue
+}
+
+// runListen starts the HTTP submission server and blocks until it exits.
+// pubKeyPath is required: every submission must carry a valid HTTP
+// Signature verifiable against that key, since listening without one would
+// execute any task an unauthenticated caller on the network submits. When
+// queue is non-nil, submitted tasks are persisted so they survive an agent
+// restart.
+func runListen(addr string, policy *Policy, reg *registry, heartbeat time.Duration, pubKeyPath string, queue *durableQueue) error {
+	if pubKeyPath == "" {
+		return fmt.Errorf("-listen requires -pubkey; refusing to accept unauthenticated remote tasks")
+	}
+	srv := &remoteServer{policy: policy, reg: reg, heartbeat: heartbeat, queue: queue}
+	pub, err := loadPublicKey(pubKeyPath)
+	if err != nil {
+		return fmt.Errorf("load public key: %w", err)
+	}
+	srv.pubKey = pub
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/tasks", srv.handleTask)
+	fmt.Fprintf(os.Stderr, "termux-ultra-agent: listening on %s\n", addr)
+	return http.ListenAndServe(addr, mux)
+}
+
+func (s *remoteServer) handleTask(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxTaskBodyBytes)
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+		} else {
+			http.Error(w, "failed to read request body", http.StatusBadRequest)
+		}
+		return
+	}
+	if s.pubKey != nil {
+		if err := verifyRequest(r, body, s.pubKey); err != nil {
+			http.Error(w, fmt.Sprintf("signature verification failed: %v", err), http.StatusUnauthorized)
+			return
+		}
+	}
+
+	var task AgentTask
+	if err := json.Unmarshal(body, &task); err != nil {
+		http.Error(w, "invalid task JSON", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	flusher, _ := w.(http.Flusher)
+	enc := json.NewEncoder(w)
+	var mu sync.Mutex
+	emit := func(ev StreamEvent) {
+		mu.Lock()
+		defer mu.Unlock()
+		enc.Encode(ev)
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+	if s.queue != nil {
+		s.queue.runTask(task, s.policy, s.heartbeat, s.reg, emit)
+	} else {
+		streamTaskTo(task, s.policy, s.heartbeat, s.reg, emit)
+	}
+}
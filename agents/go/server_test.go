@@ -0,0 +1,25 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestHandleTaskRejectsOversizedBody ensures an over-limit request body is
+// rejected by the MaxBytesReader cap before signature verification or JSON
+// parsing ever see it, so an unauthenticated caller can't exhaust memory
+// just by sending a huge body to -listen.
+func TestHandleTaskRejectsOversizedBody(t *testing.T) {
+	s := &remoteServer{}
+	body := bytes.Repeat([]byte("a"), maxTaskBodyBytes+1)
+	req := httptest.NewRequest(http.MethodPost, "/tasks", bytes.NewReader(body))
+	w := httptest.NewRecorder()
+
+	s.handleTask(w, req)
+
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected %d, got %d", http.StatusRequestEntityTooLarge, w.Code)
+	}
+}
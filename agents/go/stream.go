@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ExitResult carries the fields that only make sense on a task's terminal
+// "exit" event. It's embedded as a pointer in StreamEvent so a nil
+// *ExitResult disappears from JSON entirely on "start"/"stdout"/"stderr"/
+// "heartbeat" events instead of stamping a misleading "exit_code":0,
+// "success":false onto every in-progress event.
+type ExitResult struct {
+	ExitCode int  `json:"exit_code"`
+	Success  bool `json:"success"`
+}
+
+// StreamEvent is one line of the agent's NDJSON output protocol. A task's
+// life cycle is a "start" event, zero or more interleaved "stdout"/"stderr"
+// (and idle "heartbeat") events, and exactly one terminal "exit" event.
+// Modeled after the shape of Go's own `test2json` event stream so
+// downstream tooling can consume both uniformly.
+type StreamEvent struct {
+	TaskID string `json:"task_id"`
+	Event  string `json:"event"`
+	Seq    int64  `json:"seq"`
+	Chunk  string `json:"chunk,omitempty"`
+	*ExitResult
+	ExecTimeMs int64          `json:"execution_time_ms,omitempty"`
+	Error      string         `json:"error,omitempty"`
+	ErrorCode  string         `json:"error_code,omitempty"`
+	Truncated  bool           `json:"truncated,omitempty"`
+	TaskIDs    []string       `json:"task_ids,omitempty"`
+	Parsed     interface{}    `json:"parsed,omitempty"`
+	History    []*queueRecord `json:"history,omitempty"`
+}
+
+// eventWriter serializes NDJSON event emission across concurrently running
+// tasks so lines from different tasks never interleave mid-write.
+type eventWriter struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+var stdoutEvents = &eventWriter{enc: json.NewEncoder(os.Stdout)}
+
+func (w *eventWriter) emit(ev StreamEvent) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.enc.Encode(ev)
+}
+
+const defaultHeartbeatInterval = 10 * time.Second
+
+// errCodeCanceled is the StreamEvent.ErrorCode set on a task's exit event
+// when its context was canceled out from under it (a {"control":"cancel"}
+// message; see control.go), as distinct from errCodePolicyDenied, a wall-time
+// "timeout", or an ordinary non-zero exit.
+const errCodeCanceled = "canceled"
+
+// streamTask runs task to completion, emitting its life cycle as NDJSON
+// events on stdout rather than returning a single buffered result. It
+// registers itself in reg for the duration of the run so control messages
+// (cancel/signal/list) arriving on stdin can reach it.
+func streamTask(task AgentTask, policy *Policy, heartbeat time.Duration, reg *registry) {
+	streamTaskTo(task, policy, heartbeat, reg, stdoutEvents.emit)
+}
+
+// streamTaskTo is streamTask with the event sink made explicit, so a task
+// submitted over HTTP can stream its events back on the response body
+// instead of to the agent's own stdout.
+func streamTaskTo(task AgentTask, policy *Policy, heartbeat time.Duration, reg *registry, emit func(StreamEvent)) {
+	var seq int64
+	next := func() int64 { return atomic.AddInt64(&seq, 1) }
+
+	emit(StreamEvent{TaskID: task.ID, Event: "start", Seq: next()})
+
+	parser, err := parserFor(task.Parser, task.ParserArgs)
+	if err != nil {
+		emit(StreamEvent{TaskID: task.ID, Event: "exit", Seq: next(), ExitResult: &ExitResult{}, Error: err.Error(), ErrorCode: "parser_config_error"})
+		return
+	}
+
+	var cp *CommandPolicy
+	if policy != nil {
+		matched, err := policy.authorize(task)
+		if err != nil {
+			emit(StreamEvent{
+				TaskID: task.ID, Event: "exit", Seq: next(), ExitResult: &ExitResult{},
+				Error: err.Error(), ErrorCode: errCodePolicyDenied,
+			})
+			return
+		}
+		cp = matched
+	}
+
+	wallTime := defaultWallTime
+	if cp != nil && cp.WallTimeMs > 0 {
+		wallTime = time.Duration(cp.WallTimeMs) * time.Millisecond
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), wallTime)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, task.Command, task.Args...)
+	setProcessGroup(cmd)
+	var maxOutput int64
+	if cp != nil {
+		cmd.Dir = cp.WorkDir
+		applyChroot(cmd, cp.Chroot)
+		maxOutput = cp.MaxOutputBytes
+	}
+	if policy != nil {
+		if env := policy.scrubEnv(); env != nil {
+			cmd.Env = env
+		}
+	}
+
+	stdoutPipe, err := cmd.StdoutPipe()
+	if err != nil {
+		emit(StreamEvent{TaskID: task.ID, Event: "exit", Seq: next(), ExitResult: &ExitResult{}, Error: err.Error()})
+		return
+	}
+	stderrPipe, err := cmd.StderrPipe()
+	if err != nil {
+		emit(StreamEvent{TaskID: task.ID, Event: "exit", Seq: next(), ExitResult: &ExitResult{}, Error: err.Error()})
+		return
+	}
+
+	limiter := &outputLimiter{max: maxOutput}
+	start := time.Now()
+
+	runPipe := func() error {
+		if err := cmd.Start(); err != nil {
+			return err
+		}
+		if cp != nil {
+			applyRlimits(cmd.Process.Pid, cp)
+		}
+
+		if reg != nil {
+			reg.add(&taskHandle{id: task.ID, cancel: cancel, cmd: cmd})
+			defer reg.remove(task.ID)
+		}
+
+		done := make(chan struct{})
+		go func() {
+			select {
+			case <-ctx.Done():
+				killProcessGroup(cmd, "SIGKILL")
+			case <-done:
+			}
+		}()
+		lastOutput := time.Now().UnixNano()
+		lineEmit := func(ev StreamEvent) {
+			atomic.StoreInt64(&lastOutput, time.Now().UnixNano())
+			emit(ev)
+		}
+		if heartbeat > 0 {
+			go func() {
+				ticker := time.NewTicker(heartbeat)
+				defer ticker.Stop()
+				for {
+					select {
+					case <-ticker.C:
+						if time.Since(time.Unix(0, atomic.LoadInt64(&lastOutput))) < heartbeat {
+							continue
+						}
+						emit(StreamEvent{TaskID: task.ID, Event: "heartbeat", Seq: next()})
+					case <-done:
+						return
+					}
+				}
+			}()
+		}
+
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go streamLines(stdoutPipe, "stdout", task.ID, limiter, next, lineEmit, parser, &wg)
+		go streamLines(stderrPipe, "stderr", task.ID, limiter, next, lineEmit, parser, &wg)
+		wg.Wait()
+		close(done)
+
+		return cmd.Wait()
+	}
+
+	err = runPipe()
+	execTime := time.Since(start).Milliseconds()
+
+	exit := StreamEvent{
+		TaskID:     task.ID,
+		Event:      "exit",
+		Seq:        next(),
+		ExitResult: &ExitResult{Success: err == nil},
+		ExecTimeMs: execTime,
+		Truncated:  limiter.isTruncated(),
+	}
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		exit.ExitCode = exitErr.ExitCode()
+		exit.Error = err.Error()
+	} else if err != nil {
+		exit.Error = err.Error()
+	}
+	// A cancel (chunk0-3's {"control":"cancel"}) kills the process group,
+	// so the error above is usually an *exec.ExitError from the signal, not
+	// context.Canceled itself — check ctx.Err() independently of which
+	// branch set Error rather than only on the non-ExitError path, or a
+	// canceled task would be indistinguishable from an ordinary failure.
+	if err != nil {
+		switch ctx.Err() {
+		case context.Canceled:
+			exit.ErrorCode = errCodeCanceled
+		case context.DeadlineExceeded:
+			exit.ErrorCode = "timeout"
+		}
+	}
+	emit(exit)
+}
+
+// streamLines reads newline-delimited output from r and emits one event
+// per line, honoring the shared output budget, and reads r until it is
+// exhausted. Output after a line longer than 1MiB is read and discarded
+// without events.
+func streamLines(r io.Reader, streamName, taskID string, limiter *outputLimiter, next func() int64, emit func(StreamEvent), parser lineParser, wg *sync.WaitGroup) {
+	defer wg.Done()
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if ok, _ := limiter.allow(len(line)); !ok {
+			continue
+		}
+		ev := StreamEvent{TaskID: taskID, Event: streamName, Seq: next(), Chunk: line}
+		if parser != nil {
+			if parsed, err := parser(line); err == nil {
+				ev.Parsed = parsed
+			}
+		}
+		emit(ev)
+	}
+	io.Copy(io.Discard, r)
+}
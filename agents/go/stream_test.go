@@ -0,0 +1,38 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// TestStreamEventOmitsExitFieldsOnNonExitEvents guards against a regression
+// where exit_code/success (needed, unomitted, on a real "exit" event) leaked
+// onto every other event type because StreamEvent is one flat struct shared
+// across the whole life cycle.
+func TestStreamEventOmitsExitFieldsOnNonExitEvents(t *testing.T) {
+	for _, ev := range []StreamEvent{
+		{TaskID: "t1", Event: "start", Seq: 1},
+		{TaskID: "t1", Event: "stdout", Seq: 2, Chunk: "hi"},
+		{TaskID: "t1", Event: "heartbeat", Seq: 3},
+	} {
+		data, err := json.Marshal(ev)
+		if err != nil {
+			t.Fatalf("marshal %q event: %v", ev.Event, err)
+		}
+		if strings.Contains(string(data), "exit_code") || strings.Contains(string(data), "success") {
+			t.Errorf("%q event serialized with exit-only fields: %s", ev.Event, data)
+		}
+	}
+}
+
+func TestStreamEventIncludesExitFieldsOnExitEvent(t *testing.T) {
+	ev := StreamEvent{TaskID: "t1", Event: "exit", Seq: 4, ExitResult: &ExitResult{Success: false}}
+	data, err := json.Marshal(ev)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	if !strings.Contains(string(data), `"exit_code":0`) || !strings.Contains(string(data), `"success":false`) {
+		t.Errorf("exit event should always serialize exit_code/success explicitly, got: %s", data)
+	}
+}